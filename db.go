@@ -3,9 +3,13 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"log"
+	"os"
+	"time"
 
+	"github.com/aws/aws-dax-go-v2/dax"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
@@ -18,39 +22,127 @@ import (
 
 const TableName = "Todos"
 
+// DynamoDBAPI covers the subset of the aws-sdk-go-v2 DynamoDB client shape
+// that both *dynamodb.Client and aws-dax-go-v2's *dax.Dax satisfy, so reads
+// can transparently go through DAX while writes stay on the regular client.
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
 var db dynamodb.Client
 
+// readDb serves getItem/listItems. It points at DAX when DAX_ENDPOINT is
+// configured and reachable, otherwise it falls back to db.
+var readDb DynamoDBAPI
+
+// configureClient overrides the package-level db/readDb clients. init() only
+// runs once at process start, so tests that need to point at a DynamoDB
+// Local container spun up after that point call this instead.
+func configureClient(client dynamodb.Client) {
+	db = client
+	readDb = &db
+}
+
 func init() {
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO())
+	ctx := context.TODO()
+
+	var opts []func(*config.LoadOptions) error
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		resolver := aws.EndpointResolverWithOptionsFunc(
+			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: endpoint}, nil
+			},
+		)
+		opts = append(opts, config.WithEndpointResolverWithOptions(resolver))
+	}
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	db = *dynamodb.NewFromConfig(sdkConfig)
+	readDb = &db
+
+	if endpoint := os.Getenv("DAX_ENDPOINT"); endpoint != "" {
+		daxConfig := dax.DefaultConfig()
+		daxConfig.HostPorts = []string{endpoint}
+
+		daxClient, err := dax.New(daxConfig)
+		if err != nil {
+			log.Printf("Failed to dial DAX at %s, falling back to DynamoDB: %v", endpoint, err)
+		} else {
+			readDb = daxClient
+		}
+	}
 }
 
 type Todo struct {
+	UserId      string `json:"-" dynamodbav:"userId"`
 	Id          string `json:"id" dynamodbav:"id"`
 	Name        string `json:"name" dynamodbav:"name"`
 	Description string `json:"description" dynamodbav:"description"`
 	Status      bool   `json:"status" dynamodbav:"status"`
+	Version     int64  `json:"version" dynamodbav:"version"`
+}
+
+// ConflictError is returned by updateItem when the submitted version no
+// longer matches the server copy. Current carries the server's view of the
+// item so the caller can show the client what actually won the race.
+type ConflictError struct {
+	Current *Todo
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict: current version is %d", e.Current.Version)
+}
+
+// TransactionFailure identifies which sub-operation of a TransactWriteItems
+// call was rejected and why, as reported by DynamoDB's cancellation reasons.
+type TransactionFailure struct {
+	Index   int    `json:"index"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type TransactionConflictError struct {
+	Failures []TransactionFailure
+}
+
+func (e *TransactionConflictError) Error() string {
+	return fmt.Sprintf("transaction canceled: %d operation(s) failed", len(e.Failures))
+}
+
+func itemKey(userId, id string) (map[string]types.AttributeValue, error) {
+	key, err := attributevalue.MarshalMap(map[string]string{
+		"userId": userId,
+		"id":     id,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
 }
 
-func getItem(ctx context.Context, id string) (*Todo, error) {
-	key, err := attributevalue.Marshal(id)
+func getItem(ctx context.Context, userId, id string) (*Todo, error) {
+	key, err := itemKey(userId, id)
 	if err != nil {
 		return nil, err
 	}
 
 	input := &dynamodb.GetItemInput{
 		TableName: aws.String(TableName),
-		Key: map[string]types.AttributeValue{
-			"id": key,
-		},
+		Key:       key,
 	}
 
 	log.Printf("Calling Dynamodb with input: %v", input)
-	result, err := db.GetItem(ctx, input)
+	result, err := readDb.GetItem(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -69,43 +161,45 @@ func getItem(ctx context.Context, id string) (*Todo, error) {
 	return todo, nil
 }
 
-func listItems(ctx context.Context) ([]Todo, error) {
-	todos := make([]Todo, 0)
-	var token map[string]types.AttributeValue
-
-	for {
-		input := &dynamodb.ScanInput{
-			TableName:         aws.String(TableName),
-			ExclusiveStartKey: token,
-		}
+func listItems(ctx context.Context, userId string, limit int32, startKey map[string]types.AttributeValue) ([]Todo, map[string]types.AttributeValue, error) {
+	keyExpr, err := expression.NewBuilder().WithKeyCondition(
+		expression.Key("userId").Equal(expression.Value(userId)),
+	).Build()
+	if err != nil {
+		return nil, nil, err
+	}
 
-		result, err := db.Scan(ctx, input)
-		if err != nil {
-			return nil, err
-		}
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(TableName),
+		KeyConditionExpression:    keyExpr.KeyCondition(),
+		ExpressionAttributeNames:  keyExpr.Names(),
+		ExpressionAttributeValues: keyExpr.Values(),
+		ExclusiveStartKey:         startKey,
+		Limit:                     aws.Int32(limit),
+	}
 
-		var fetchedTodos []Todo
-		err = attributevalue.UnmarshalListOfMaps(result.Items, &fetchedTodos)
-		if err != nil {
-			return nil, err
-		}
+	result, err := readDb.Query(ctx, input)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		todos = append(todos, fetchedTodos...)
-		token = result.LastEvaluatedKey
-		if token == nil {
-			break
-		}
+	todos := make([]Todo, 0)
+	err = attributevalue.UnmarshalListOfMaps(result.Items, &todos)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return todos, nil
+	return todos, result.LastEvaluatedKey, nil
 }
 
-func insertItem(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
+func insertItem(ctx context.Context, userId string, createTodo CreateTodo) (*Todo, error) {
 	todo := Todo{
+		UserId:      userId,
 		Name:        createTodo.Name,
 		Description: createTodo.Description,
 		Status:      false,
 		Id:          uuid.NewString(),
+		Version:     1,
 	}
 
 	item, err := attributevalue.MarshalMap(todo)
@@ -131,17 +225,15 @@ func insertItem(ctx context.Context, createTodo CreateTodo) (*Todo, error) {
 	return &todo, nil
 }
 
-func deleteItem(ctx context.Context, id string) (*Todo, error) {
-	key, err := attributevalue.Marshal(id)
+func deleteItem(ctx context.Context, userId, id string) (*Todo, error) {
+	key, err := itemKey(userId, id)
 	if err != nil {
 		return nil, err
 	}
 
 	input := &dynamodb.DeleteItemInput{
-		TableName: aws.String(TableName),
-		Key: map[string]types.AttributeValue{
-			"id": key,
-		},
+		TableName:    aws.String(TableName),
+		Key:          key,
 		ReturnValues: types.ReturnValue(*aws.String("ALL_OLD")),
 	}
 
@@ -163,8 +255,8 @@ func deleteItem(ctx context.Context, id string) (*Todo, error) {
 	return todo, nil
 }
 
-func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, error) {
-	key, err := attributevalue.Marshal(id)
+func updateItem(ctx context.Context, userId, id string, updateTodo UpdateTodo, expectedVersion int64) (*Todo, error) {
+	key, err := itemKey(userId, id)
 	if err != nil {
 		return nil, err
 	}
@@ -179,11 +271,17 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 		).Set(
 			expression.Name("status"),
 			expression.Value(updateTodo.Status),
+		).Set(
+			expression.Name("version"),
+			expression.Plus(expression.Name("version"), expression.Value(1)),
 		),
 	).WithCondition(
-		expression.Equal(
-			expression.Name("id"),
-			expression.Value(id),
+		expression.And(
+			expression.Equal(
+				expression.Name("version"),
+				expression.Value(expectedVersion),
+			),
+			expression.AttributeExists(expression.Name("id")),
 		),
 	).Build()
 	if err != nil {
@@ -191,15 +289,14 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 	}
 
 	input := &dynamodb.UpdateItemInput{
-		Key: map[string]types.AttributeValue{
-			"id": key,
-		},
-		TableName:                 aws.String(TableName),
-		UpdateExpression:          expr.Update(),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		ConditionExpression:       expr.Condition(),
-		ReturnValues:              types.ReturnValue(*aws.String("ALL_NEW")),
+		Key:                                 key,
+		TableName:                           aws.String(TableName),
+		UpdateExpression:                    expr.Update(),
+		ExpressionAttributeNames:            expr.Names(),
+		ExpressionAttributeValues:           expr.Values(),
+		ConditionExpression:                 expr.Condition(),
+		ReturnValues:                        types.ReturnValue(*aws.String("ALL_NEW")),
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	}
 
 	res, err := db.UpdateItem(ctx, input)
@@ -208,7 +305,17 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 		if errors.As(err, &smErr) {
 			var condCheckFailed *types.ConditionalCheckFailedException
 			if errors.As(err, &condCheckFailed) {
-				return nil, nil
+				if condCheckFailed.Item == nil {
+					return nil, nil
+				}
+
+				current := new(Todo)
+				unmarshalErr := attributevalue.UnmarshalMap(condCheckFailed.Item, current)
+				if unmarshalErr != nil {
+					return nil, unmarshalErr
+				}
+
+				return nil, &ConflictError{Current: current}
 			}
 		}
 
@@ -227,3 +334,206 @@ func updateItem(ctx context.Context, id string, updateTodo UpdateTodo) (*Todo, e
 
 	return todo, nil
 }
+
+const batchWriteChunkSize = 25
+
+func batchInsertItems(ctx context.Context, userId string, createTodos []CreateTodo) ([]Todo, error) {
+	todos := make([]Todo, 0, len(createTodos))
+	for _, createTodo := range createTodos {
+		todos = append(todos, Todo{
+			UserId:      userId,
+			Name:        createTodo.Name,
+			Description: createTodo.Description,
+			Status:      false,
+			Id:          uuid.NewString(),
+			Version:     1,
+		})
+	}
+
+	for start := 0; start < len(todos); start += batchWriteChunkSize {
+		end := start + batchWriteChunkSize
+		if end > len(todos) {
+			end = len(todos)
+		}
+
+		writeRequests := make([]types.WriteRequest, 0, end-start)
+		for _, todo := range todos[start:end] {
+			item, err := attributevalue.MarshalMap(todo)
+			if err != nil {
+				return nil, err
+			}
+
+			writeRequests = append(writeRequests, types.WriteRequest{
+				PutRequest: &types.PutRequest{Item: item},
+			})
+		}
+
+		if err := batchWriteWithRetry(ctx, writeRequests); err != nil {
+			return nil, err
+		}
+	}
+
+	return todos, nil
+}
+
+// batchWriteWithRetry submits writeRequests via BatchWriteItem, resubmitting
+// any UnprocessedItems DynamoDB throttled with a capped exponential backoff.
+func batchWriteWithRetry(ctx context.Context, writeRequests []types.WriteRequest) error {
+	requestItems := map[string][]types.WriteRequest{TableName: writeRequests}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; attempt < 5 && len(requestItems) > 0; attempt++ {
+		res, err := db.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: requestItems})
+		if err != nil {
+			return err
+		}
+
+		requestItems = res.UnprocessedItems
+		if len(requestItems) == 0 {
+			return nil
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if len(requestItems) > 0 {
+		return errors.New("batch write did not complete after retries")
+	}
+
+	return nil
+}
+
+func transactWriteItems(ctx context.Context, userId string, ops []TransactionOp) ([]Todo, error) {
+	items := make([]types.TransactWriteItem, 0, len(ops))
+	results := make([]Todo, len(ops))
+
+	for i, op := range ops {
+		switch op.Operation {
+		case "create":
+			todo := Todo{
+				UserId:      userId,
+				Name:        op.Create.Name,
+				Description: op.Create.Description,
+				Status:      false,
+				Id:          uuid.NewString(),
+				Version:     1,
+			}
+
+			item, err := attributevalue.MarshalMap(todo)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, types.TransactWriteItem{
+				Put: &types.Put{
+					TableName: aws.String(TableName),
+					Item:      item,
+				},
+			})
+			results[i] = todo
+
+		case "update":
+			key, err := itemKey(userId, op.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			expr, err := expression.NewBuilder().WithUpdate(
+				expression.Set(
+					expression.Name("name"),
+					expression.Value(op.Update.Name),
+				).Set(
+					expression.Name("description"),
+					expression.Value(op.Update.Description),
+				).Set(
+					expression.Name("status"),
+					expression.Value(op.Update.Status),
+				).Set(
+					expression.Name("version"),
+					expression.Plus(expression.Name("version"), expression.Value(1)),
+				),
+			).WithCondition(
+				expression.And(
+					expression.Equal(expression.Name("version"), expression.Value(op.Version)),
+					expression.AttributeExists(expression.Name("id")),
+				),
+			).Build()
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName:                 aws.String(TableName),
+					Key:                       key,
+					UpdateExpression:          expr.Update(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+					ConditionExpression:       expr.Condition(),
+				},
+			})
+			results[i] = Todo{
+				UserId:      userId,
+				Id:          op.Id,
+				Name:        op.Update.Name,
+				Description: op.Update.Description,
+				Status:      op.Update.Status,
+				Version:     op.Version + 1,
+			}
+
+		case "delete":
+			key, err := itemKey(userId, op.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			expr, err := expression.NewBuilder().WithCondition(
+				expression.AttributeExists(expression.Name("id")),
+			).Build()
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName:                aws.String(TableName),
+					Key:                      key,
+					ConditionExpression:      expr.Condition(),
+					ExpressionAttributeNames: expr.Names(),
+				},
+			})
+			results[i] = Todo{UserId: userId, Id: op.Id}
+		}
+	}
+
+	_, err := db.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			failures := make([]TransactionFailure, 0)
+			for i, reason := range canceled.CancellationReasons {
+				if reason.Code == nil || *reason.Code == "None" {
+					continue
+				}
+
+				message := ""
+				if reason.Message != nil {
+					message = *reason.Message
+				}
+
+				failures = append(failures, TransactionFailure{
+					Index:   i,
+					Code:    *reason.Code,
+					Message: message,
+				})
+			}
+
+			return nil, &TransactionConflictError{Failures: failures}
+		}
+
+		return nil, err
+	}
+
+	return results, nil
+}
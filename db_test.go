@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/CrazyRoka/todo-app-lambda/internal/testfixtures"
+)
+
+func TestMain(m *testing.M) {
+	if !testfixtures.DockerAvailable() {
+		log.Print("docker not available, skipping dynamodb-local integration tests")
+		os.Exit(0)
+	}
+
+	ctx := context.Background()
+
+	teardown, err := testfixtures.StartContainer(ctx)
+	if err != nil {
+		log.Fatalf("failed to start dynamodb-local: %v", err)
+	}
+	defer teardown()
+
+	client, err := testfixtures.NewClient(ctx)
+	if err != nil {
+		log.Fatalf("failed to build dynamodb-local client: %v", err)
+	}
+	// init() already ran against the real AWS config by this point, so the
+	// package-level db/readDb clients need to be repointed explicitly.
+	configureClient(*client)
+
+	os.Exit(m.Run())
+}
+
+func TestNotFound(t *testing.T) {
+	ctx := context.Background()
+	userId := uuid.NewString()
+	id := uuid.NewString()
+
+	cases := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{
+			name: "getItem",
+			run: func(t *testing.T) {
+				todo, err := getItem(ctx, userId, id)
+				if err != nil {
+					t.Fatalf("getItem returned error: %v", err)
+				}
+
+				if todo != nil {
+					t.Fatalf("expected no todo, got %+v", todo)
+				}
+			},
+		},
+		{
+			name: "deleteItem",
+			run: func(t *testing.T) {
+				todo, err := deleteItem(ctx, userId, id)
+				if err != nil {
+					t.Fatalf("deleteItem returned error: %v", err)
+				}
+
+				if todo != nil {
+					t.Fatalf("expected no todo, got %+v", todo)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, tc.run)
+	}
+}
+
+func TestUpdateItem_VersionConflict(t *testing.T) {
+	cases := []struct {
+		name          string
+		versionOffset int64
+	}{
+		{name: "version one ahead", versionOffset: 1},
+		{name: "version far ahead", versionOffset: 5},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			userId := uuid.NewString()
+
+			created, err := insertItem(ctx, userId, CreateTodo{Name: "test", Description: "test"})
+			if err != nil {
+				t.Fatalf("insertItem returned error: %v", err)
+			}
+
+			_, err = updateItem(ctx, userId, created.Id, UpdateTodo{
+				Name:        "updated",
+				Description: "updated",
+				Status:      true,
+			}, created.Version+tc.versionOffset)
+
+			var conflict *ConflictError
+			if !errors.As(err, &conflict) {
+				t.Fatalf("expected *ConflictError, got %v", err)
+			}
+
+			if conflict.Current.Version != created.Version {
+				t.Fatalf("expected current version %d, got %d", created.Version, conflict.Current.Version)
+			}
+		})
+	}
+}
+
+func TestListItems_Pagination(t *testing.T) {
+	cases := []struct {
+		name  string
+		total int
+		limit int32
+	}{
+		{name: "evenly divides", total: 4, limit: 2},
+		{name: "remainder page", total: 5, limit: 2},
+		{name: "single page", total: 3, limit: 10},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			userId := uuid.NewString()
+
+			for i := 0; i < tc.total; i++ {
+				if _, err := insertItem(ctx, userId, CreateTodo{Name: "test", Description: "test"}); err != nil {
+					t.Fatalf("insertItem returned error: %v", err)
+				}
+			}
+
+			var fetched []Todo
+			var startKey map[string]types.AttributeValue
+			for {
+				page, lastKey, err := listItems(ctx, userId, tc.limit, startKey)
+				if err != nil {
+					t.Fatalf("listItems returned error: %v", err)
+				}
+
+				fetched = append(fetched, page...)
+				if lastKey == nil {
+					break
+				}
+				startKey = lastKey
+			}
+
+			if len(fetched) != tc.total {
+				t.Fatalf("expected %d todos, got %d", tc.total, len(fetched))
+			}
+		})
+	}
+}
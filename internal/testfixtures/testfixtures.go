@@ -0,0 +1,127 @@
+// Package testfixtures spins up a local DynamoDB instance for integration
+// tests and provisions the Todos table with the schema the lambda expects.
+package testfixtures
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	DefaultEndpoint = "http://localhost:8000"
+	TableName       = "Todos"
+)
+
+// Endpoint returns the DynamoDB Local endpoint tests should talk to,
+// honoring DYNAMODB_ENDPOINT if the caller has overridden it.
+func Endpoint() string {
+	if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+
+	return DefaultEndpoint
+}
+
+// DockerAvailable reports whether a docker binary is on PATH, so callers can
+// skip the integration harness instead of failing outright in CI images or
+// sandboxes that don't have Docker.
+func DockerAvailable() bool {
+	_, err := exec.LookPath("docker")
+	return err == nil
+}
+
+// StartContainer brings up the dynamodb-local service defined in
+// docker-compose.yml and blocks until the Todos table is ready. The returned
+// func tears the container back down.
+func StartContainer(ctx context.Context) (func(), error) {
+	if err := exec.CommandContext(ctx, "docker", "compose", "up", "-d", "dynamodb-local").Run(); err != nil {
+		return nil, err
+	}
+
+	teardown := func() {
+		_ = exec.Command("docker", "compose", "down").Run()
+	}
+
+	client, err := NewClient(ctx)
+	if err != nil {
+		teardown()
+		return nil, err
+	}
+
+	if err := waitForTable(ctx, client); err != nil {
+		teardown()
+		return nil, err
+	}
+
+	return teardown, nil
+}
+
+// NewClient builds a DynamoDB client pointed at the local endpoint.
+func NewClient(ctx context.Context) (*dynamodb.Client, error) {
+	resolver := aws.EndpointResolverWithOptionsFunc(
+		func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: Endpoint()}, nil
+		},
+	)
+
+	sdkConfig, err := config.LoadDefaultConfig(ctx,
+		config.WithEndpointResolverWithOptions(resolver),
+		config.WithRegion("us-east-1"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return dynamodb.NewFromConfig(sdkConfig), nil
+}
+
+// EnsureTable creates the Todos table with its expected composite key
+// schema, tolerating a table that already exists from a prior run.
+func EnsureTable(ctx context.Context, client *dynamodb.Client) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(TableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("userId"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("id"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("userId"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("id"), KeyType: types.KeyTypeRange},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		var inUse *types.ResourceInUseException
+		if errors.As(err, &inUse) {
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func waitForTable(ctx context.Context, client *dynamodb.Client) error {
+	deadline := time.Now().Add(30 * time.Second)
+
+	for {
+		if err := EnsureTable(ctx, client); err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for dynamodb-local")
+		}
+
+		time.Sleep(time.Second)
+	}
+}
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -22,8 +28,114 @@ type CreateTodo struct {
 	Description string `json:"description" validate:"required"`
 }
 
+type ListTodosResponse struct {
+	Todos     []Todo `json:"todos"`
+	NextToken string `json:"nextToken,omitempty"`
+}
+
+type TransactionOp struct {
+	Operation string      `json:"operation" validate:"required,oneof=create update delete"`
+	Id        string      `json:"id,omitempty" validate:"required_unless=Operation create"`
+	Version   int64       `json:"version,omitempty"`
+	Create    *CreateTodo `json:"create,omitempty" validate:"required_if=Operation create"`
+	Update    *UpdateTodo `json:"update,omitempty" validate:"required_if=Operation update"`
+}
+
+const defaultListLimit = 20
+
 var validate *validator.Validate = validator.New()
 
+func getHeader(req events.APIGatewayProxyRequest, name string) (string, bool) {
+	if value, ok := req.Headers[name]; ok {
+		return value, true
+	}
+
+	if value, ok := req.Headers[strings.ToLower(name)]; ok {
+		return value, true
+	}
+
+	return "", false
+}
+
+func conflictResponse(current *Todo) (events.APIGatewayProxyResponse, error) {
+	json, err := json.Marshal(current)
+	if err != nil {
+		return serverError(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusConflict,
+		Body:       string(json),
+		Headers: map[string]string{
+			"ETag": strconv.FormatInt(current.Version, 10),
+		},
+	}, nil
+}
+
+func transactionConflictResponse(conflict *TransactionConflictError) (events.APIGatewayProxyResponse, error) {
+	json, err := json.Marshal(conflict.Failures)
+	if err != nil {
+		return serverError(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusConflict,
+		Body:       string(json),
+	}, nil
+}
+
+func getUserId(req events.APIGatewayProxyRequest) (string, error) {
+	claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		return "", errors.New("missing authorizer claims")
+	}
+
+	userId, ok := claims["sub"].(string)
+	if !ok || userId == "" {
+		return "", errors.New("missing sub claim")
+	}
+
+	return userId, nil
+}
+
+func encodeNextToken(lastKey map[string]types.AttributeValue) (string, error) {
+	if lastKey == nil {
+		return "", nil
+	}
+
+	var raw map[string]interface{}
+	err := attributevalue.UnmarshalMap(lastKey, &raw)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(bytes), nil
+}
+
+func decodeNextToken(token string) (map[string]types.AttributeValue, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	bytes, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	err = json.Unmarshal(bytes, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return attributevalue.MarshalMap(raw)
+}
+
 func router(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	log.Printf("Received req %#v", req)
 
@@ -42,18 +154,24 @@ func router(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIG
 }
 
 func processGet(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
 	id, ok := req.PathParameters["id"]
 	if !ok {
-		return processGetTodos(ctx)
+		return processGetTodos(ctx, req, userId)
 	} else {
-		return processGetTodo(ctx, id)
+		return processGetTodo(ctx, userId, id)
 	}
 }
 
-func processGetTodo(ctx context.Context, id string) (events.APIGatewayProxyResponse, error) {
+func processGetTodo(ctx context.Context, userId, id string) (events.APIGatewayProxyResponse, error) {
 	log.Printf("Received GET todo request with id = %s", id)
 
-	todo, err := getItem(ctx, id)
+	todo, err := getItem(ctx, userId, id)
 	if err != nil {
 		return serverError(err)
 	}
@@ -74,15 +192,36 @@ func processGetTodo(ctx context.Context, id string) (events.APIGatewayProxyRespo
 	}, nil
 }
 
-func processGetTodos(ctx context.Context) (events.APIGatewayProxyResponse, error) {
+func processGetTodos(ctx context.Context, req events.APIGatewayProxyRequest, userId string) (events.APIGatewayProxyResponse, error) {
 	log.Print("Received GET todos request")
 
-	todos, err := listItems(ctx)
+	limit := defaultListLimit
+	if rawLimit, ok := req.QueryStringParameters["limit"]; ok {
+		parsedLimit, err := strconv.Atoi(rawLimit)
+		if err != nil || parsedLimit <= 0 {
+			log.Printf("Invalid limit: %v", rawLimit)
+			return clientError(http.StatusBadRequest)
+		}
+		limit = parsedLimit
+	}
+
+	startKey, err := decodeNextToken(req.QueryStringParameters["nextToken"])
+	if err != nil {
+		log.Printf("Invalid nextToken: %v", err)
+		return clientError(http.StatusBadRequest)
+	}
+
+	todos, lastKey, err := listItems(ctx, userId, int32(limit), startKey)
 	if err != nil {
 		return serverError(err)
 	}
 
-	json, err := json.Marshal(todos)
+	nextToken, err := encodeNextToken(lastKey)
+	if err != nil {
+		return serverError(err)
+	}
+
+	json, err := json.Marshal(ListTodosResponse{Todos: todos, NextToken: nextToken})
 	if err != nil {
 		return serverError(err)
 	}
@@ -95,8 +234,22 @@ func processGetTodos(ctx context.Context) (events.APIGatewayProxyResponse, error
 }
 
 func processPost(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if strings.HasSuffix(req.Path, "/todos/batch") {
+		return processBatchPost(ctx, req)
+	}
+
+	if strings.HasSuffix(req.Path, "/todos/transaction") {
+		return processTransactionPost(ctx, req)
+	}
+
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
 	var createTodo CreateTodo
-	err := json.Unmarshal([]byte(req.Body), &createTodo)
+	err = json.Unmarshal([]byte(req.Body), &createTodo)
 	if err != nil {
 		log.Printf("Can't unmarshal body: %v", err)
 		return clientError(http.StatusUnprocessableEntity)
@@ -109,7 +262,7 @@ func processPost(ctx context.Context, req events.APIGatewayProxyRequest) (events
 	}
 	log.Printf("Received POST request with item: %+v", createTodo)
 
-	res, err := insertItem(ctx, createTodo)
+	res, err := insertItem(ctx, userId, createTodo)
 	if err != nil {
 		return serverError(err)
 	}
@@ -129,14 +282,103 @@ func processPost(ctx context.Context, req events.APIGatewayProxyRequest) (events
 	}, nil
 }
 
+func processBatchPost(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
+	var createTodos []CreateTodo
+	err = json.Unmarshal([]byte(req.Body), &createTodos)
+	if err != nil {
+		log.Printf("Can't unmarshal body: %v", err)
+		return clientError(http.StatusUnprocessableEntity)
+	}
+
+	for _, createTodo := range createTodos {
+		if err := validate.Struct(&createTodo); err != nil {
+			log.Printf("Invalid body: %v", err)
+			return clientError(http.StatusBadRequest)
+		}
+	}
+	log.Printf("Received batch POST request with %d items", len(createTodos))
+
+	res, err := batchInsertItems(ctx, userId, createTodos)
+	if err != nil {
+		return serverError(err)
+	}
+	log.Printf("Inserted %d todos", len(res))
+
+	json, err := json.Marshal(res)
+	if err != nil {
+		return serverError(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Body:       string(json),
+	}, nil
+}
+
+func processTransactionPost(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
+	var ops []TransactionOp
+	err = json.Unmarshal([]byte(req.Body), &ops)
+	if err != nil {
+		log.Printf("Can't unmarshal body: %v", err)
+		return clientError(http.StatusUnprocessableEntity)
+	}
+
+	for _, op := range ops {
+		if err := validate.Struct(&op); err != nil {
+			log.Printf("Invalid body: %v", err)
+			return clientError(http.StatusBadRequest)
+		}
+	}
+	log.Printf("Received transaction POST request with %d operations", len(ops))
+
+	res, err := transactWriteItems(ctx, userId, ops)
+	if err != nil {
+		var conflict *TransactionConflictError
+		if errors.As(err, &conflict) {
+			return transactionConflictResponse(conflict)
+		}
+
+		return serverError(err)
+	}
+	log.Printf("Committed transaction with %d operations", len(res))
+
+	json, err := json.Marshal(res)
+	if err != nil {
+		return serverError(err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Body:       string(json),
+	}, nil
+}
+
 func processDelete(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
 	id, ok := req.PathParameters["id"]
 	if !ok {
 		return clientError(http.StatusBadRequest)
 	}
 	log.Printf("Received DELETE request with id = %s", id)
 
-	todo, err := deleteItem(ctx, id)
+	todo, err := deleteItem(ctx, userId, id)
 	if err != nil {
 		return serverError(err)
 	}
@@ -158,13 +400,19 @@ func processDelete(ctx context.Context, req events.APIGatewayProxyRequest) (even
 }
 
 func processPut(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	userId, err := getUserId(req)
+	if err != nil {
+		log.Printf("Can't resolve user id: %v", err)
+		return clientError(http.StatusUnauthorized)
+	}
+
 	id, ok := req.PathParameters["id"]
 	if !ok {
 		return clientError(http.StatusBadRequest)
 	}
 
 	var updateTodo UpdateTodo
-	err := json.Unmarshal([]byte(req.Body), &updateTodo)
+	err = json.Unmarshal([]byte(req.Body), &updateTodo)
 	if err != nil {
 		log.Printf("Can't unmarshal body: %v", err)
 		return clientError(http.StatusUnprocessableEntity)
@@ -177,8 +425,25 @@ func processPut(ctx context.Context, req events.APIGatewayProxyRequest) (events.
 	}
 	log.Printf("Received PUT request with item: %+v", updateTodo)
 
-	res, err := updateItem(ctx, id, updateTodo)
+	ifMatch, ok := getHeader(req, "If-Match")
+	if !ok {
+		log.Print("Missing If-Match header")
+		return clientError(http.StatusBadRequest)
+	}
+
+	expectedVersion, err := strconv.ParseInt(ifMatch, 10, 64)
 	if err != nil {
+		log.Printf("Invalid If-Match header: %v", ifMatch)
+		return clientError(http.StatusBadRequest)
+	}
+
+	res, err := updateItem(ctx, userId, id, updateTodo, expectedVersion)
+	if err != nil {
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			return conflictResponse(conflict.Current)
+		}
+
 		return serverError(err)
 	}
 
@@ -198,6 +463,7 @@ func processPut(ctx context.Context, req events.APIGatewayProxyRequest) (events.
 		Body:       string(json),
 		Headers: map[string]string{
 			"Location": fmt.Sprintf("/todo/%s", res.Id),
+			"ETag":     strconv.FormatInt(res.Version, 10),
 		},
 	}, nil
 }